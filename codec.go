@@ -0,0 +1,222 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// addressCodec knows how to decode and encode the address block of a single
+// (Version, AddressFamilyAndProtocol) combination. Decode reads src, dst and
+// their ports from r; length is the declared size of the address block (v2
+// only - v1 codecs ignore it, since v1 addresses are newline-terminated
+// text rather than a fixed-size block). Encode writes the same information
+// back out in that combination's wire format.
+type addressCodec interface {
+	Decode(r io.Reader, length uint16) (src, dst net.Addr, sport, dport uint16, err error)
+	Encode(w io.Writer, src, dst net.Addr, sport, dport uint16) error
+}
+
+var (
+	// ErrCantReadVersion1Header is returned when a v1 address codec can't
+	// read or parse its text line.
+	ErrCantReadVersion1Header = errors.New("proxyproto: can't read version 1 header line")
+	// ErrInvalidPortNumber is returned when a v1 address codec can't parse
+	// a port field as a 16-bit unsigned integer.
+	ErrInvalidPortNumber = errors.New("proxyproto: invalid port number")
+)
+
+type addressCodecKey struct {
+	version Version
+	fam     AddressFamilyAndProtocol
+}
+
+var (
+	addressCodecsMu sync.RWMutex
+	addressCodecs   = map[addressCodecKey]addressCodec{}
+)
+
+// RegisterAddressCodec registers c as the codec responsible for decoding and
+// encoding addresses for the given protocol version and address family,
+// letting callers layer experimental or vendor-specific families onto the
+// package without forking it. Safe for concurrent use with parsing/writing.
+func RegisterAddressCodec(version byte, fam AddressFamilyAndProtocol, c addressCodec) {
+	addressCodecsMu.Lock()
+	defer addressCodecsMu.Unlock()
+	addressCodecs[addressCodecKey{Version(version), fam}] = c
+}
+
+// addressCodecFor looks up the codec registered for version and fam.
+func addressCodecFor(version Version, fam AddressFamilyAndProtocol) (addressCodec, bool) {
+	addressCodecsMu.RLock()
+	defer addressCodecsMu.RUnlock()
+	c, ok := addressCodecs[addressCodecKey{version, fam}]
+	return c, ok
+}
+
+func init() {
+	RegisterAddressCodec(2, TCPv4, v2INETCodec{bytes: 4})
+	RegisterAddressCodec(2, UDPv4, v2INETCodec{bytes: 4})
+	RegisterAddressCodec(2, TCPv6, v2INETCodec{bytes: 16})
+	RegisterAddressCodec(2, UDPv6, v2INETCodec{bytes: 16})
+	RegisterAddressCodec(2, UnixStream, v2UnixCodec{})
+	RegisterAddressCodec(2, UnixDatagram, v2UnixCodec{})
+
+	RegisterAddressCodec(1, TCPv4, v1TextCodec{})
+	RegisterAddressCodec(1, TCPv6, v1TextCodec{})
+	RegisterAddressCodec(1, UNSPEC, v1UnknownCodec{})
+}
+
+// v2INETCodec handles PROXY v2 INET and INET6: a fixed src/dst address pair
+// of bytes bytes each, followed by two big-endian port uint16s.
+type v2INETCodec struct {
+	bytes int
+}
+
+func (c v2INETCodec) Decode(r io.Reader, _ uint16) (net.Addr, net.Addr, uint16, uint16, error) {
+	src := make([]byte, c.bytes)
+	dst := make([]byte, c.bytes)
+	var ports _ports
+	if _, err := io.ReadFull(r, src); err != nil {
+		return nil, nil, 0, 0, ErrInvalidAddress
+	}
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return nil, nil, 0, 0, ErrInvalidAddress
+	}
+	if err := binary.Read(r, binary.BigEndian, &ports); err != nil {
+		return nil, nil, 0, 0, ErrInvalidAddress
+	}
+	return &net.IPAddr{IP: src}, &net.IPAddr{IP: dst}, ports.SrcPort, ports.DstPort, nil
+}
+
+func (c v2INETCodec) Encode(w io.Writer, src, dst net.Addr, sport, dport uint16) error {
+	network := INET4
+	if c.bytes == 16 {
+		network = INET6
+	}
+	srcAddr, err := net.ResolveIPAddr(network, src.String())
+	if err != nil {
+		return err
+	}
+	dstAddr, err := net.ResolveIPAddr(network, dst.String())
+	if err != nil {
+		return err
+	}
+	srcBytes, dstBytes := srcAddr.IP, dstAddr.IP
+	if c.bytes == 4 {
+		srcBytes, dstBytes = srcBytes.To4(), dstBytes.To4()
+	} else {
+		srcBytes, dstBytes = srcBytes.To16(), dstBytes.To16()
+	}
+	if _, err := w.Write(srcBytes); err != nil {
+		return err
+	}
+	if _, err := w.Write(dstBytes); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, _ports{SrcPort: sport, DstPort: dport})
+}
+
+// v2UnixCodec handles PROXY v2 UNIX: a fixed 108-byte src/dst socket path
+// pair and no ports.
+type v2UnixCodec struct{}
+
+func (v2UnixCodec) Decode(r io.Reader, _ uint16) (net.Addr, net.Addr, uint16, uint16, error) {
+	var addr _addrUnix
+	if err := binary.Read(r, binary.BigEndian, &addr); err != nil {
+		return nil, nil, 0, 0, ErrInvalidAddress
+	}
+	src, err := net.ResolveUnixAddr("unix", string(bytes.TrimRight(addr.Src[:], "\x00")))
+	if err != nil {
+		return nil, nil, 0, 0, ErrCantResolveSourceUnixAddress
+	}
+	dst, err := net.ResolveUnixAddr("unix", string(bytes.TrimRight(addr.Dst[:], "\x00")))
+	if err != nil {
+		return nil, nil, 0, 0, ErrCantResolveDestinationUnixAddress
+	}
+	return src, dst, 0, 0, nil
+}
+
+func (v2UnixCodec) Encode(w io.Writer, src, dst net.Addr, _, _ uint16) error {
+	var addr _addrUnix
+	copy(addr.Src[:], src.String())
+	copy(addr.Dst[:], dst.String())
+	return binary.Write(w, binary.BigEndian, addr)
+}
+
+// v1TextCodec handles the PROXY v1 TCP4 and TCP6 address blocks: a single
+// space-separated, CRLF-terminated text line of "src dst sport dport". It
+// reads one byte at a time directly off r - never wrapping r in a fresh
+// bufio.Reader - so it consumes exactly the header line and leaves
+// whatever follows untouched for the caller's own reader.
+type v1TextCodec struct{}
+
+func (v1TextCodec) Decode(r io.Reader, _ uint16) (net.Addr, net.Addr, uint16, uint16, error) {
+	var line bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, nil, 0, 0, ErrCantReadVersion1Header
+		}
+		line.WriteByte(b[0])
+		if b[0] == '\n' {
+			break
+		}
+	}
+
+	fields := strings.Fields(line.String())
+	if len(fields) != 4 {
+		return nil, nil, 0, 0, ErrCantReadVersion1Header
+	}
+	srcIP := net.ParseIP(fields[0])
+	dstIP := net.ParseIP(fields[1])
+	if srcIP == nil || dstIP == nil {
+		return nil, nil, 0, 0, ErrInvalidAddress
+	}
+	sport, err := strconv.ParseUint(fields[2], 10, 16)
+	if err != nil {
+		return nil, nil, 0, 0, ErrInvalidPortNumber
+	}
+	dport, err := strconv.ParseUint(fields[3], 10, 16)
+	if err != nil {
+		return nil, nil, 0, 0, ErrInvalidPortNumber
+	}
+	return &net.IPAddr{IP: srcIP}, &net.IPAddr{IP: dstIP}, uint16(sport), uint16(dport), nil
+}
+
+func (v1TextCodec) Encode(w io.Writer, src, dst net.Addr, sport, dport uint16) error {
+	_, err := fmt.Fprintf(w, "%s %s %d %d\r\n", src.String(), dst.String(), sport, dport)
+	return err
+}
+
+// v1UnknownCodec handles the PROXY v1 UNKNOWN family, which carries no
+// addresses at all.
+type v1UnknownCodec struct{}
+
+func (v1UnknownCodec) Decode(_ io.Reader, _ uint16) (net.Addr, net.Addr, uint16, uint16, error) {
+	return nil, nil, 0, 0, nil
+}
+
+func (v1UnknownCodec) Encode(_ io.Writer, _, _ net.Addr, _, _ uint16) error {
+	return nil
+}
+
+// countingReader wraps an io.Reader and tracks how many bytes have been
+// read through it, so parseVersion2 can tell how much of the declared
+// address-block length a dispatched codec actually consumed.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
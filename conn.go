@@ -0,0 +1,139 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn wraps a net.Conn accepted behind a PROXY protocol capable load
+// balancer. The PROXY header is parsed lazily, on the first call to Read,
+// RemoteAddr or LocalAddr, according to the configured Policy.
+type Conn struct {
+	conn               net.Conn
+	bufReader          *bufio.Reader
+	proxyHeaderTimeout time.Duration
+	policy             Policy
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// NewConn wraps conn so that its PROXY header is parsed according to
+// policy, with header parsing aborted after proxyHeaderTimeout has elapsed
+// (zero means no deadline). A nil policy always USEs the header.
+func NewConn(conn net.Conn, proxyHeaderTimeout time.Duration, policy Policy) *Conn {
+	return &Conn{
+		conn:               conn,
+		bufReader:          bufio.NewReader(conn),
+		proxyHeaderTimeout: proxyHeaderTimeout,
+		policy:             policy,
+	}
+}
+
+// readHeader evaluates the connection's Policy and, unless it says to
+// IGNORE or REJECT, parses the PROXY header exactly once.
+func (p *Conn) readHeader() {
+	p.once.Do(func() {
+		action := USE
+		if p.policy != nil {
+			var err error
+			action, err = p.policy(p.conn.RemoteAddr())
+			if err != nil {
+				p.err = err
+				return
+			}
+		}
+
+		switch action {
+		case REJECT:
+			p.conn.Close()
+			p.err = ErrRejectedConnection
+			return
+		case IGNORE:
+			return
+		}
+
+		if p.proxyHeaderTimeout > 0 {
+			deadline := time.Now().Add(p.proxyHeaderTimeout)
+			if err := p.conn.SetReadDeadline(deadline); err != nil {
+				p.err = err
+				return
+			}
+			defer p.conn.SetReadDeadline(time.Time{})
+		}
+
+		header, err := Read(p.bufReader)
+		if err != nil {
+			if action == REQUIRE {
+				p.err = err
+			}
+			return
+		}
+		p.header = header
+	})
+}
+
+// Header returns the parsed PROXY header, if one was present and the Policy
+// allowed it to be read.
+func (p *Conn) Header() *Header {
+	p.readHeader()
+	return p.header
+}
+
+func (p *Conn) Read(b []byte) (int, error) {
+	p.readHeader()
+	if p.err != nil {
+		return 0, p.err
+	}
+	return p.bufReader.Read(b)
+}
+
+func (p *Conn) Write(b []byte) (int, error) {
+	return p.conn.Write(b)
+}
+
+func (p *Conn) Close() error {
+	return p.conn.Close()
+}
+
+// LocalAddr returns the underlying connection's local address.
+func (p *Conn) LocalAddr() net.Addr {
+	p.readHeader()
+	return p.conn.LocalAddr()
+}
+
+// RemoteAddr returns the PROXY-advertised source address once the header
+// has been successfully parsed, otherwise the underlying connection's
+// remote address.
+func (p *Conn) RemoteAddr() net.Addr {
+	p.readHeader()
+	if p.header == nil || p.header.SourceAddress == nil {
+		return p.conn.RemoteAddr()
+	}
+	switch addr := p.header.SourceAddress.(type) {
+	case *net.UnixAddr:
+		return addr
+	case *net.IPAddr:
+		if p.header.TransportProtocol == UDPv4 || p.header.TransportProtocol == UDPv6 {
+			return &net.UDPAddr{IP: addr.IP, Port: int(p.header.SourcePort)}
+		}
+		return &net.TCPAddr{IP: addr.IP, Port: int(p.header.SourcePort)}
+	default:
+		return p.conn.RemoteAddr()
+	}
+}
+
+func (p *Conn) SetDeadline(t time.Time) error {
+	return p.conn.SetDeadline(t)
+}
+
+func (p *Conn) SetReadDeadline(t time.Time) error {
+	return p.conn.SetReadDeadline(t)
+}
+
+func (p *Conn) SetWriteDeadline(t time.Time) error {
+	return p.conn.SetWriteDeadline(t)
+}
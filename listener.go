@@ -0,0 +1,34 @@
+package proxyproto
+
+import (
+	"net"
+	"time"
+)
+
+// Listener wraps a net.Listener so that every accepted connection is
+// returned as a Conn, ready to have its PROXY header parsed according to
+// Policy.
+type Listener struct {
+	Listener           net.Listener
+	Policy             Policy
+	ProxyHeaderTimeout time.Duration
+}
+
+// Accept waits for and returns the next connection, wrapped in a Conn.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn, l.ProxyHeaderTimeout, l.Policy), nil
+}
+
+// Close closes the underlying listener.
+func (l *Listener) Close() error {
+	return l.Listener.Close()
+}
+
+// Addr returns the underlying listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.Listener.Addr()
+}
@@ -0,0 +1,88 @@
+package proxyproto
+
+import (
+	"errors"
+	"net"
+)
+
+// PolicyAction determines how a Conn should treat the connection from a
+// given downstream address: whether to trust and parse its PROXY header,
+// pass it through untouched, require a header to be present, or refuse the
+// connection outright.
+type PolicyAction int
+
+const (
+	// USE parses the PROXY header, if present, and uses it to replace the
+	// connection's advertised remote address.
+	USE PolicyAction = iota
+	// IGNORE skips header parsing entirely and passes the connection
+	// through as-is.
+	IGNORE
+	// REJECT closes the connection without reading from it.
+	REJECT
+	// REQUIRE behaves like USE but treats a missing or malformed header as
+	// an error.
+	REQUIRE
+)
+
+// Policy decides the PolicyAction to take for a connection based on the
+// address of the immediate downstream peer (e.g. a load balancer).
+type Policy func(downstream net.Addr) (PolicyAction, error)
+
+// ErrRejectedConnection is returned when a Policy rejects a connection.
+var ErrRejectedConnection = errors.New("proxyproto: connection rejected by policy")
+
+// WhitelistPolicy builds a Policy from a CIDR whitelist of trusted
+// downstream proxies. Connections from a downstream address inside the
+// whitelist are USEd; connections from anywhere else are REJECTed when
+// strict is true, or IGNOREd (passed through untouched) when strict is
+// false.
+func WhitelistPolicy(cidrs []string, strict bool) (Policy, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return func(downstream net.Addr) (PolicyAction, error) {
+		ip, err := ipFromAddr(downstream)
+		if err != nil {
+			return REJECT, err
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return USE, nil
+			}
+		}
+		if strict {
+			return REJECT, nil
+		}
+		return IGNORE, nil
+	}, nil
+}
+
+// ipFromAddr extracts the IP component from the net.Addr implementations
+// that can appear as a connection's RemoteAddr.
+func ipFromAddr(addr net.Addr) (net.IP, error) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, nil
+	case *net.UDPAddr:
+		return a.IP, nil
+	case *net.IPAddr:
+		return a.IP, nil
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, errors.New("proxyproto: cannot parse downstream address " + addr.String())
+		}
+		return ip, nil
+	}
+}
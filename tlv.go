@@ -0,0 +1,218 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// TLV is a single Type-Length-Value entry as carried after the fixed address
+// block of a PROXY protocol v2 header. See section 2.2 of the spec.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Registered TLV types, see section 2.2 of the PROXY protocol spec.
+const (
+	PP2_TYPE_ALPN      = 0x01
+	PP2_TYPE_AUTHORITY = 0x02
+	PP2_TYPE_CRC32C    = 0x03
+	PP2_TYPE_NOOP      = 0x04
+	PP2_TYPE_UNIQUE_ID = 0x05
+	PP2_TYPE_SSL       = 0x20
+	PP2_TYPE_NETNS     = 0x30
+
+	// Vendor specific TLV types, documented by their respective vendors.
+	PP2_TYPE_AWS   = 0xEA
+	PP2_TYPE_AZURE = 0xEE
+)
+
+// Sub-types nested inside the value of a PP2_TYPE_SSL TLV, see section 2.2.5.
+const (
+	PP2_SUBTYPE_SSL_VERSION = 0x21
+	PP2_SUBTYPE_SSL_CN      = 0x22
+	PP2_SUBTYPE_SSL_CIPHER  = 0x23
+	PP2_SUBTYPE_SSL_SIG_ALG = 0x24
+	PP2_SUBTYPE_SSL_KEY_ALG = 0x25
+)
+
+// tlvHeaderLen is the size in bytes of a TLV's type+length prefix.
+const tlvHeaderLen = 3
+
+// crc32cTLVLen is the size in bytes of a PP2_TYPE_CRC32C TLV's value.
+const crc32cTLVLen = 4
+
+var (
+	// ErrTruncatedTLV is returned when a TLV's declared length runs past the
+	// end of the buffer it is being decoded from.
+	ErrTruncatedTLV = errors.New("proxyproto: truncated TLV")
+	// ErrInvalidCRC32C is returned when a header carries a PP2_TYPE_CRC32C
+	// TLV whose value does not match the checksum of the header that
+	// contains it.
+	ErrInvalidCRC32C = errors.New("proxyproto: invalid CRC32C checksum")
+	// ErrOversizedTLV is returned when a TLV's Value is too large to encode
+	// in the 16-bit length field the wire format allots it.
+	ErrOversizedTLV = errors.New("proxyproto: TLV value exceeds 65535 bytes")
+)
+
+// PP2SSL is the decoded form of a PP2_TYPE_SSL TLV's value: a client/verify
+// flag pair followed by zero or more nested TLVs (PP2_SUBTYPE_SSL_*).
+type PP2SSL struct {
+	Client byte
+	Verify uint32
+	TLVs   []TLV
+}
+
+// walkTLVs iteratively decodes a sequence of type(1)+len(2 BE)+value(len)
+// triples until data is exhausted, invoking fn for each with the byte offset
+// of its value within data.
+func walkTLVs(data []byte, fn func(typ byte, valueOffset int, value []byte)) error {
+	pos := 0
+	for len(data) > 0 {
+		if len(data) < tlvHeaderLen {
+			return ErrTruncatedTLV
+		}
+		typ := data[0]
+		length := binary.BigEndian.Uint16(data[1:3])
+		data = data[tlvHeaderLen:]
+		pos += tlvHeaderLen
+		if int(length) > len(data) {
+			return ErrTruncatedTLV
+		}
+		fn(typ, pos, data[:length:length])
+		data = data[length:]
+		pos += int(length)
+	}
+	return nil
+}
+
+// decodeTLVs decodes the full sequence of TLVs in data.
+func decodeTLVs(data []byte) ([]TLV, error) {
+	var tlvs []TLV
+	err := walkTLVs(data, func(typ byte, _ int, value []byte) {
+		tlvs = append(tlvs, TLV{Type: typ, Value: value})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tlvs, nil
+}
+
+// encodeTLVs appends the wire form of tlvs to buf. It returns
+// ErrOversizedTLV, without writing anything, if any tlv's Value is too
+// long to fit the wire format's 16-bit length field.
+func encodeTLVs(buf *bytes.Buffer, tlvs []TLV) error {
+	for _, tlv := range tlvs {
+		if len(tlv.Value) > 0xFFFF {
+			return ErrOversizedTLV
+		}
+	}
+	for _, tlv := range tlvs {
+		buf.WriteByte(tlv.Type)
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(tlv.Value)))
+		buf.Write(length)
+		buf.Write(tlv.Value)
+	}
+	return nil
+}
+
+// decodePP2SSL decodes the value of a PP2_TYPE_SSL TLV: a client byte, a
+// 4-byte big-endian verify result, and any further nested TLVs.
+func decodePP2SSL(value []byte) (*PP2SSL, error) {
+	if len(value) < 5 {
+		return nil, ErrTruncatedTLV
+	}
+	nested, err := decodeTLVs(value[5:])
+	if err != nil {
+		return nil, err
+	}
+	return &PP2SSL{
+		Client: value[0],
+		Verify: binary.BigEndian.Uint32(value[1:5]),
+		TLVs:   nested,
+	}, nil
+}
+
+// tlv returns the first TLV of the given type, if present.
+func (header *Header) tlv(typ byte) (TLV, bool) {
+	for _, t := range header.TLVs {
+		if t.Type == typ {
+			return t, true
+		}
+	}
+	return TLV{}, false
+}
+
+// SSLInfo returns the decoded PP2_TYPE_SSL TLV, if the header carries one.
+func (header *Header) SSLInfo() (*PP2SSL, bool) {
+	t, ok := header.tlv(PP2_TYPE_SSL)
+	if !ok {
+		return nil, false
+	}
+	ssl, err := decodePP2SSL(t.Value)
+	if err != nil {
+		return nil, false
+	}
+	return ssl, true
+}
+
+// ALPN returns the value of the PP2_TYPE_ALPN TLV, if present.
+func (header *Header) ALPN() (string, bool) {
+	t, ok := header.tlv(PP2_TYPE_ALPN)
+	if !ok {
+		return "", false
+	}
+	return string(t.Value), true
+}
+
+// Authority returns the value of the PP2_TYPE_AUTHORITY TLV, if present.
+func (header *Header) Authority() (string, bool) {
+	t, ok := header.tlv(PP2_TYPE_AUTHORITY)
+	if !ok {
+		return "", false
+	}
+	return string(t.Value), true
+}
+
+// UniqueID returns the value of the PP2_TYPE_UNIQUE_ID TLV, if present.
+func (header *Header) UniqueID() ([]byte, bool) {
+	t, ok := header.tlv(PP2_TYPE_UNIQUE_ID)
+	if !ok {
+		return nil, false
+	}
+	return t.Value, true
+}
+
+// findCRC32C locates the value of a PP2_TYPE_CRC32C TLV within the raw,
+// still-encoded TLV byte sequence, returning its byte offset within tlvData
+// so the caller can zero it before checksumming.
+func findCRC32C(tlvData []byte) (offset int, value []byte, ok bool) {
+	_ = walkTLVs(tlvData, func(typ byte, valueOffset int, tlvValue []byte) {
+		if typ == PP2_TYPE_CRC32C && !ok {
+			offset, value, ok = valueOffset, tlvValue, true
+		}
+	})
+	return offset, value, ok
+}
+
+// verifyCRC32C checks raw, the complete encoded header, against the 4-byte
+// value of its PP2_TYPE_CRC32C TLV (if any). crcOffset is the byte offset of
+// that value within raw. The checksum is computed over raw with the CRC32C
+// value bytes zeroed, per section 2.2.1 of the spec.
+func verifyCRC32C(raw []byte, crcOffset int, want []byte) error {
+	zeroed := make([]byte, len(raw))
+	copy(zeroed, raw)
+	for i := 0; i < crc32cTLVLen; i++ {
+		zeroed[crcOffset+i] = 0
+	}
+	got := crc32.Checksum(zeroed, crc32.MakeTable(crc32.Castagnoli))
+	gotBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(gotBytes, got)
+	if !bytes.Equal(gotBytes, want) {
+		return ErrInvalidCRC32C
+	}
+	return nil
+}
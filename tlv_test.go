@@ -0,0 +1,112 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// TestTLVRoundTrip encodes a set of TLVs (ALPN, Authority and Unique ID)
+// and checks that decoding the result reproduces the original values
+// byte-for-byte.
+func TestTLVRoundTrip(t *testing.T) {
+	want := []TLV{
+		{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		{Type: PP2_TYPE_AUTHORITY, Value: []byte("example.com")},
+		{Type: PP2_TYPE_UNIQUE_ID, Value: []byte{0x01, 0x02, 0x03, 0x04}},
+	}
+
+	var buf bytes.Buffer
+	if err := encodeTLVs(&buf, want); err != nil {
+		t.Fatalf("encodeTLVs: %v", err)
+	}
+
+	got, err := decodeTLVs(buf.Bytes())
+	if err != nil {
+		t.Fatalf("decodeTLVs: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d TLVs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Type != want[i].Type || !bytes.Equal(got[i].Value, want[i].Value) {
+			t.Errorf("TLV %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// TestCRC32CRoundTrip builds a HAProxy-style header tail (fixed address
+// block followed by encapsulated TLVs, the last of which is PP2_TYPE_CRC32C)
+// and checks that verifyCRC32C accepts the checksum HAProxy's own algorithm
+// produces: Castagnoli CRC32 over the whole header with the CRC32C TLV's
+// value bytes zeroed (spec section 2.2.1).
+func TestCRC32CRoundTrip(t *testing.T) {
+	// A minimal stand-in for the bytes preceding the TLV block: signature,
+	// command/family bytes and a fixed v4 address block.
+	header := []byte{
+		0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A, // SIGV2
+		0x21, 0x11, // command, family
+		0x00, 0x00, // length placeholder, filled in below
+		127, 0, 0, 1, 127, 0, 0, 2, 0x1F, 0x90, 0x00, 0x50, // src/dst/ports
+	}
+
+	tlvs := []TLV{
+		{Type: PP2_TYPE_ALPN, Value: []byte("h2")},
+		{Type: PP2_TYPE_CRC32C, Value: make([]byte, crc32cTLVLen)},
+	}
+	var tlvBuf bytes.Buffer
+	if err := encodeTLVs(&tlvBuf, tlvs); err != nil {
+		t.Fatalf("encodeTLVs: %v", err)
+	}
+	tlvData := tlvBuf.Bytes()
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(header)-16+len(tlvData)))
+
+	full := append(append([]byte{}, header...), tlvData...)
+
+	crcOffset, _, ok := findCRC32C(tlvData)
+	if !ok {
+		t.Fatal("findCRC32C: CRC32C TLV not found")
+	}
+	absCRCOffset := len(header) + crcOffset
+
+	// Compute the checksum the way HAProxy does: over the full header with
+	// the CRC32C value zeroed, which it already is here.
+	want := crc32.Checksum(full, crc32.MakeTable(crc32.Castagnoli))
+	wantBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(wantBytes, want)
+	copy(full[absCRCOffset:], wantBytes)
+
+	// Re-run findCRC32C against the now-populated TLV data so verifyCRC32C
+	// sees the real checksum bytes, not the zeroed placeholder.
+	tlvData = full[len(header):]
+	_, gotValue, ok := findCRC32C(tlvData)
+	if !ok {
+		t.Fatal("findCRC32C: CRC32C TLV not found after fill-in")
+	}
+	if err := verifyCRC32C(full, absCRCOffset, gotValue); err != nil {
+		t.Fatalf("verifyCRC32C rejected a valid checksum: %v", err)
+	}
+
+	// Corrupting a single byte of the header must now fail verification.
+	corrupt := append([]byte{}, full...)
+	corrupt[0] ^= 0xFF
+	if err := verifyCRC32C(corrupt, absCRCOffset, gotValue); err != ErrInvalidCRC32C {
+		t.Fatalf("verifyCRC32C on corrupted header = %v, want ErrInvalidCRC32C", err)
+	}
+}
+
+// TestEncodeTLVsOversized checks that a TLV whose Value can't fit the wire
+// format's 16-bit length field is rejected rather than silently truncated.
+func TestEncodeTLVsOversized(t *testing.T) {
+	tlvs := []TLV{
+		{Type: PP2_TYPE_AUTHORITY, Value: make([]byte, 0x10000)},
+	}
+	var buf bytes.Buffer
+	if err := encodeTLVs(&buf, tlvs); err != ErrOversizedTLV {
+		t.Fatalf("encodeTLVs with an oversized value = %v, want ErrOversizedTLV", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("encodeTLVs wrote %d bytes on error, want 0", buf.Len())
+	}
+}
@@ -5,27 +5,12 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
-	"net"
-	"strconv"
 )
 
-var (
-	lengthV4Bytes = func() []byte {
-		a := make([]byte, 2)
-		binary.BigEndian.PutUint16(a, 12)
-		return a
-	}()
-	lengthV6Bytes = func() []byte {
-		a := make([]byte, 2)
-		binary.BigEndian.PutUint16(a, 36)
-		return a
-	}()
-	lengthUnixBytes = func() []byte {
-		a := make([]byte, 2)
-		binary.BigEndian.PutUint16(a, 218)
-		return a
-	}()
-)
+// Version identifies the wire framing of a PROXY protocol header: 1 for the
+// human-readable text format, 2 for the binary format this file parses and
+// writes.
+type Version byte
 
 type _ports struct {
 	SrcPort uint16
@@ -81,9 +66,6 @@ func parseVersion2(reader *bufio.Reader) (header *Header, err error) {
 		return nil, ErrCantReadAddressFamilyAndProtocol
 	}
 	header.TransportProtocol = AddressFamilyAndProtocol(b14)
-	if _, ok := supportedTransportProtocol[header.TransportProtocol]; !ok {
-		return nil, ErrUnsupportedAddressFamilyAndProtocol
-	}
 
 	// Read addresses and ports
 	var length uint16
@@ -94,82 +76,124 @@ func parseVersion2(reader *bufio.Reader) (header *Header, err error) {
 		return nil, ErrInvalidLength
 	}
 
-	if header.TransportProtocol.IsIPv4() {
-		var addr _addr4
-		if err := binary.Read(io.LimitReader(reader, int64(length)), binary.BigEndian, &addr); err != nil {
-			return nil, ErrInvalidAddress
-		}
-		header.SourceAddress = &net.IPAddr{IP: addr.Src[:], Zone: ""}
-		header.DestinationAddress = &net.IPAddr{IP: addr.Dst[:], Zone: ""}
-		header.SourcePort = addr.SrcPort
-		header.DestinationPort = addr.DstPort
-	} else if header.TransportProtocol.IsIPv6() {
-		var addr _addr6
-		if err := binary.Read(io.LimitReader(reader, int64(length)), binary.BigEndian, &addr); err != nil {
-			return nil, ErrInvalidAddress
-		}
-		header.SourceAddress = &net.IPAddr{IP: addr.Src[:], Zone: ""}
-		header.DestinationAddress = &net.IPAddr{IP: addr.Dst[:], Zone: ""}
-		header.SourcePort = addr.SrcPort
-		header.DestinationPort = addr.DstPort
-	} else if header.TransportProtocol.IsUnix() {
-		var addr _addrUnix
-		if err := binary.Read(io.LimitReader(reader, int64(length)), binary.BigEndian, &addr); err != nil {
-			return nil, ErrInvalidAddress
+	codec, ok := addressCodecFor(Version(header.Version), header.TransportProtocol)
+	if !ok {
+		return nil, ErrUnsupportedAddressFamilyAndProtocol
+	}
+
+	var addrRaw bytes.Buffer
+	cr := &countingReader{r: io.TeeReader(io.LimitReader(reader, int64(length)), &addrRaw)}
+	src, dst, sport, dport, err := codec.Decode(cr, length)
+	if err != nil {
+		return nil, err
+	}
+	header.SourceAddress = src
+	header.DestinationAddress = dst
+	header.SourcePort = sport
+	header.DestinationPort = dport
+	addrBlockSize := cr.n
+
+	// Consume and decode any encapsulated TLVs trailing the fixed address
+	// block (section 2.2 of the spec).
+	tlvData := make([]byte, int(length)-addrBlockSize)
+	if len(tlvData) > 0 {
+		if _, err := io.ReadFull(reader, tlvData); err != nil {
+			return nil, ErrInvalidLength
 		}
-		if header.SourceAddress, err = net.ResolveUnixAddr("unix", string(addr.Src[:])); err != nil {
-			return nil, ErrCantResolveSourceUnixAddress
+		if header.TLVs, err = decodeTLVs(tlvData); err != nil {
+			return nil, err
 		}
-		if header.DestinationAddress, err = net.ResolveUnixAddr("unix", string(addr.Dst[:])); err != nil {
-			return nil, ErrCantResolveDestinationUnixAddress
+		if crcOffset, want, ok := findCRC32C(tlvData); ok {
+			lengthBytes := make([]byte, 2)
+			binary.BigEndian.PutUint16(lengthBytes, length)
+
+			raw := make([]byte, 0, len(SIGV2)+2+2+addrRaw.Len()+len(tlvData))
+			raw = append(raw, SIGV2...)
+			raw = append(raw, b13, b14)
+			raw = append(raw, lengthBytes...)
+			raw = append(raw, addrRaw.Bytes()...)
+			raw = append(raw, tlvData...)
+
+			if err := verifyCRC32C(raw, len(SIGV2)+2+2+addrBlockSize+crcOffset, want); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	// TODO add encapsulated TLV support
-
 	return header, nil
 }
 
+// writeVersion2 emits a struct-for-struct binary image of header, dispatching
+// to the same addressCodec parseVersion2 decodes through, so the two stay in
+// lock-step by construction.
 func (header *Header) writeVersion2(w io.Writer) (int64, error) {
 	var buf bytes.Buffer
 	buf.Write(SIGV2)
 	buf.WriteByte(header.Command.toByte())
 	buf.WriteByte(header.TransportProtocol.toByte())
-	// TODO add encapsulated TLV length
-	var addrSrc, addrDst []byte
-	if header.TransportProtocol.IsIPv4() {
-		buf.Write(lengthV4Bytes)
-		src, _ := net.ResolveIPAddr(INET4, header.SourceAddress.String())
-		addrSrc = src.IP.To4()
-		dst, _ := net.ResolveIPAddr(INET4, header.DestinationAddress.String())
-		addrDst = dst.IP.To4()
-	} else if header.TransportProtocol.IsIPv6() {
-		buf.Write(lengthV6Bytes)
-		src, _ := net.ResolveIPAddr(INET6, header.SourceAddress.String())
-		addrSrc = src.IP.To16()
-		dst, _ := net.ResolveIPAddr(INET6, header.DestinationAddress.String())
-		addrDst = dst.IP.To16()
-	} else if header.TransportProtocol.IsUnix() {
-		buf.Write(lengthUnixBytes)
-		// TODO is below right?
-		addrSrc = []byte(header.SourceAddress.String())
-		addrDst = []byte(header.DestinationAddress.String())
+
+	// This function always emits the v2 wire format, regardless of
+	// header.Version (see Format), so the codec lookup is pinned to v2.
+	codec, ok := addressCodecFor(2, header.TransportProtocol)
+	if !ok {
+		return 0, ErrUnsupportedAddressFamilyAndProtocol
+	}
+
+	var addrBuf bytes.Buffer
+	if err := codec.Encode(&addrBuf, header.SourceAddress, header.DestinationAddress, header.SourcePort, header.DestinationPort); err != nil {
+		return 0, err
 	}
-	buf.Write(addrSrc)
-	buf.Write(addrDst)
-	buf.WriteString(strconv.Itoa(int(header.SourcePort)))
-	buf.WriteString(strconv.Itoa(int(header.DestinationPort)))
+
+	var tlvBuf bytes.Buffer
+	if err := encodeTLVs(&tlvBuf, header.TLVs); err != nil {
+		return 0, err
+	}
+
+	// The address-block length is no longer a fixed per-family constant: it
+	// must also account for any encapsulated TLVs trailing the addresses.
+	length := addrBuf.Len() + tlvBuf.Len()
+	lengthBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(lengthBytes, uint16(length))
+	buf.Write(lengthBytes)
+
+	buf.Write(addrBuf.Bytes())
+	buf.Write(tlvBuf.Bytes())
 
 	return buf.WriteTo(w)
 }
 
+// validateLength checks that length is at least large enough to hold the
+// fixed address block for the header's transport protocol; any bytes beyond
+// that are encapsulated TLVs. Families outside the three built-ins (e.g. one
+// layered on via RegisterAddressCodec) have no known minimum here, so they
+// are left for their addressCodec's Decode to reject if too short.
 func (header *Header) validateLength(length uint16) bool {
 	if header.TransportProtocol.IsIPv4() {
-		return length == 12
+		return length >= uint16(binary.Size(_addr4{}))
 	} else if header.TransportProtocol.IsIPv6() {
-		return length == 36
+		return length >= uint16(binary.Size(_addr6{}))
 	} else if header.TransportProtocol.IsUnix() {
-		return length == 218
+		return length >= uint16(binary.Size(_addrUnix{}))
+	}
+	return true
+}
+
+// Format encodes header as the wire representation of the given protocol
+// version (1 or 2), regardless of header.Version, so callers can choose
+// which framing to emit.
+func (header *Header) Format(version Version) ([]byte, error) {
+	var buf bytes.Buffer
+	switch version {
+	case 1:
+		if _, err := header.writeVersion1(&buf); err != nil {
+			return nil, err
+		}
+	case 2:
+		if _, err := header.writeVersion2(&buf); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedProtocolVersionAndCommand
 	}
-	return false
+	return buf.Bytes(), nil
 }